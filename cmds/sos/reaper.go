@@ -0,0 +1,88 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RunReaper periodically unregisters dead entries: ones whose PID no
+// longer exists, whose HealthURL stopped answering with 200, or whose TTL
+// has passed. It runs until stop is closed.
+func (s *SosService) RunReaper(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.reapOnce()
+		}
+	}
+}
+
+func (s *SosService) reapOnce() {
+	// Snapshot and run the (possibly slow) health checks without holding
+	// rWLock, so a reap pass against several slow/unreachable HealthURLs
+	// doesn't stall every Read/Register/Unregister/List/Watch caller for up
+	// to len(entries)*2s.
+	entries := s.SnapshotRegistry()
+
+	dead := map[string]Entry{}
+	for name, e := range entries {
+		switch {
+		case e.expired():
+			dead[name] = e
+		case e.PID != 0 && !pidAlive(e.PID):
+			dead[name] = e
+		case e.HealthURL != "" && !healthOK(e.HealthURL):
+			dead[name] = e
+		}
+	}
+	if len(dead) == 0 {
+		return
+	}
+
+	s.rWLock.Lock()
+	defer s.rWLock.Unlock()
+	var reaped bool
+	for name, was := range dead {
+		// A client may have Unregistered this stale entry and Registered a
+		// fresh one under the same name while we were running health
+		// checks above; only delete if it's still the exact entry we
+		// evaluated, not whatever's there now.
+		if cur, ok := s.registry.Entries[name]; ok && cur == was {
+			delete(s.registry.Entries, name)
+			reaped = true
+		}
+	}
+	if !reaped {
+		return
+	}
+	s.registry.Revision++
+	s.persistLocked()
+}
+
+// pidAlive sends signal 0, which performs no action but still fails with
+// ESRCH if pid doesn't exist (or EPERM if it exists but we can't signal
+// it -- either way, not ours to reap).
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+var healthCheckClient = http.Client{Timeout: 2 * time.Second}
+
+func healthOK(url string) bool {
+	resp, err := healthCheckClient.Get(url)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	gorillarpc "github.com/gorilla/rpc"
+	gorillajson "github.com/gorilla/rpc/json"
+)
+
+// defaultWatchTimeout bounds a Watch RPC's long poll; clients that want a
+// different wait should set WatchArgs.TimeoutMS.
+const defaultWatchTimeout = 30 * time.Second
+
+// RegistryRPC exposes SosService as a gorilla/rpc JSON-RPC service, so
+// clients like a wifi UI can Register/Unregister/Read/List/Watch without
+// scraping the plain HTTP handler's output.
+type RegistryRPC struct {
+	sos *SosService
+}
+
+type RegisterArgs struct {
+	Name  string
+	Entry Entry
+}
+type RegisterReply struct{}
+
+func (r *RegistryRPC) Register(req *http.Request, args *RegisterArgs, reply *RegisterReply) error {
+	return r.sos.Register(args.Name, args.Entry)
+}
+
+type UnregisterArgs struct {
+	Name string
+}
+type UnregisterReply struct{}
+
+func (r *RegistryRPC) Unregister(req *http.Request, args *UnregisterArgs, reply *UnregisterReply) error {
+	return r.sos.Unregister(args.Name)
+}
+
+type ReadArgs struct {
+	Name string
+}
+type ReadReply struct {
+	Port uint
+}
+
+func (r *RegistryRPC) Read(req *http.Request, args *ReadArgs, reply *ReadReply) error {
+	port, err := r.sos.Read(args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Port = port
+	return nil
+}
+
+type ListArgs struct{}
+type ListReply struct {
+	Entries map[string]Entry
+}
+
+func (r *RegistryRPC) List(req *http.Request, args *ListArgs, reply *ListReply) error {
+	reply.Entries = r.sos.List()
+	return nil
+}
+
+type WatchArgs struct {
+	// Since is the revision the client last saw; Watch returns as soon
+	// as the registry moves past it.
+	Since uint64
+	// TimeoutMS overrides defaultWatchTimeout when nonzero.
+	TimeoutMS int64
+}
+type WatchReply struct {
+	Entries  map[string]Entry
+	Revision uint64
+}
+
+func (r *RegistryRPC) Watch(req *http.Request, args *WatchArgs, reply *WatchReply) error {
+	timeout := defaultWatchTimeout
+	if args.TimeoutMS != 0 {
+		timeout = time.Duration(args.TimeoutMS) * time.Millisecond
+	}
+	entries, rev := r.sos.Watch(args.Since, timeout)
+	reply.Entries, reply.Revision = entries, rev
+	return nil
+}
+
+// serviceHandler is the plain "existing" HTTP handler: GET /services lists
+// every entry, GET /services/<name> reads one.
+func serviceHandler(s *SosService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[len("/services/"):]
+		if name == "" {
+			json.NewEncoder(w).Encode(s.List())
+			return
+		}
+		port, err := s.Read(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(Entry{Port: port})
+	}
+}
+
+// startServer loads/creates the registry at registryPath, starts the
+// reaper, and serves both the plain HTTP handler and the JSON-RPC endpoint.
+func startServer(registryPath string) error {
+	sos, err := NewSosService(registryPath)
+	if err != nil {
+		return fmt.Errorf("sosd: %v", err)
+	}
+
+	stop := make(chan struct{})
+	go sos.RunReaper(10*time.Second, stop)
+
+	rpcServer := gorillarpc.NewServer()
+	rpcServer.RegisterCodec(gorillajson.NewCodec(), "application/json")
+	if err := rpcServer.RegisterService(&RegistryRPC{sos: sos}, ""); err != nil {
+		return fmt.Errorf("sosd: register rpc service: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/services/", serviceHandler(sos))
+	mux.Handle("/rpc", rpcServer)
+
+	return http.ListenAndServe(":9999", mux)
+}
@@ -2,57 +2,189 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// sosd is u-root's tiny service-discovery daemon: services register the
+// port they're listening on (plus a bit of health metadata), and other
+// u-root commands -- the wifi UI chief among them -- look that port up
+// instead of hardcoding it.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
-type Registry map[string]uint
+// DefaultRegistryPath is where the registry is persisted so it survives
+// sosd restarts.
+const DefaultRegistryPath = "/var/run/sos/registry.json"
 
+// Entry is everything the registry remembers about one registered service.
+type Entry struct {
+	Port uint `json:"port"`
+	// PID is the process that registered this entry. If it's no longer
+	// running, the reaper unregisters the entry.
+	PID int `json:"pid,omitempty"`
+	// HealthURL, if set, is polled by the reaper; a failing or non-200
+	// response unregisters the entry the same as a dead PID.
+	HealthURL string `json:"health_url,omitempty"`
+	// TTL, if nonzero, also expires the entry once this long has passed
+	// since Registered, regardless of PID/health.
+	TTL        time.Duration `json:"ttl,omitempty"`
+	Registered time.Time     `json:"registered"`
+}
+
+// expired reports whether e's TTL has passed.
+func (e Entry) expired() bool {
+	return e.TTL != 0 && time.Since(e.Registered) > e.TTL
+}
+
+// Registry is the full set of registered services. Revision increments on
+// every mutation so Watch can report "what changed since you last looked".
+type Registry struct {
+	Entries  map[string]Entry `json:"entries"`
+	Revision uint64           `json:"revision"`
+}
+
+// SosService is sosd's in-memory registry, persisted to disk on every change.
+// Methods must take a pointer receiver: a value receiver would copy the
+// mutex (and thus provide no mutual exclusion at all) and, worse, mutate a
+// throwaway copy of the map instead of the real one.
 type SosService struct {
 	rWLock   sync.RWMutex
 	registry Registry
+	path     string
+}
+
+// NewSosService creates a registry backed by path, loading any
+// previously-persisted entries. A missing file is not an error -- it just
+// means sosd has never run on this system before.
+func NewSosService(path string) (*SosService, error) {
+	s := &SosService{
+		registry: Registry{Entries: make(map[string]Entry)},
+		path:     path,
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("registry %v: %v", path, err)
+	}
+	if err := json.Unmarshal(b, &s.registry); err != nil {
+		return nil, fmt.Errorf("registry %v: %v", path, err)
+	}
+	return s, nil
 }
 
-func (SosService s) Read(serviceName string) (uint, error) {
+// persistLocked writes the registry to s.path. Callers must hold rWLock for
+// writing. The write goes to a temp file in the same directory followed by
+// a rename, so a crash mid-write can never leave a truncated registry.json
+// behind.
+func (s *SosService) persistLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.registry)
+	if err != nil {
+		return fmt.Errorf("marshal registry: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("registry dir: %v", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("registry %v: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("registry %v: %v", s.path, err)
+	}
+	return nil
+}
+
+func (s *SosService) Read(serviceName string) (uint, error) {
 	s.rWLock.RLock()
 	defer s.rWLock.RUnlock()
-	port, exists := s.registry[serviceName]
+	entry, exists := s.registry.Entries[serviceName]
 	if !exists {
 		return 0, fmt.Errorf("%v is not in the registry", serviceName)
 	}
-	return port, nil
+	return entry.Port, nil
 }
 
-func (SosService s) Register(serviceName string, portNum uint) error {
+// Register adds serviceName with the given metadata. e.Registered is set to
+// now regardless of what the caller passed in.
+func (s *SosService) Register(serviceName string, e Entry) error {
 	s.rWLock.Lock()
 	defer s.rWLock.Unlock()
-	_, exists := s.registry[serviceName]
-	if exists {
+	if _, exists := s.registry.Entries[serviceName]; exists {
 		return fmt.Errorf("%v already exists", serviceName)
 	}
-	s.registry[serviceName] = portNum
-	return nil
+	e.Registered = time.Now()
+	s.registry.Entries[serviceName] = e
+	s.registry.Revision++
+	return s.persistLocked()
 }
 
-func (SosService s) Unregister(serviceName string) {
+func (s *SosService) Unregister(serviceName string) error {
 	s.rWLock.Lock()
 	defer s.rWLock.Unlock()
-	delete(s.registry, serviceName)
+	if _, exists := s.registry.Entries[serviceName]; !exists {
+		return nil
+	}
+	delete(s.registry.Entries, serviceName)
+	s.registry.Revision++
+	return s.persistLocked()
 }
 
-func (SosService s) SnapshotRegistry() Registry {
+// List returns every registered entry, keyed by service name.
+func (s *SosService) List() map[string]Entry {
+	return s.SnapshotRegistry()
+}
+
+// SnapshotRegistry returns a copy of the current entries, safe for the
+// caller to range over without holding any lock.
+func (s *SosService) SnapshotRegistry() map[string]Entry {
 	s.rWLock.RLock()
 	defer s.rWLock.RUnlock()
-	snapshot := make(map[string]uint)
-	for name, port := range s.registry {
-		snapshot[name] = port
+	snapshot := make(map[string]Entry, len(s.registry.Entries))
+	for name, e := range s.registry.Entries {
+		snapshot[name] = e
 	}
 	return snapshot
 }
 
+// revision returns the registry's current revision counter, for Watch.
+func (s *SosService) revision() uint64 {
+	s.rWLock.RLock()
+	defer s.rWLock.RUnlock()
+	return s.registry.Revision
+}
+
+// Watch blocks until the registry's revision moves past since, or timeout
+// elapses, then returns the current entries and revision. Clients (e.g. a
+// wifi UI) poll-less-ly react to registry churn by calling Watch again with
+// the revision they just got back.
+func (s *SosService) Watch(since uint64, timeout time.Duration) (map[string]Entry, uint64) {
+	deadline := time.Now().Add(timeout)
+	for s.revision() <= since && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	s.rWLock.RLock()
+	defer s.rWLock.RUnlock()
+	snapshot := make(map[string]Entry, len(s.registry.Entries))
+	for name, e := range s.registry.Entries {
+		snapshot[name] = e
+	}
+	return snapshot, s.registry.Revision
+}
+
 func main() {
-	startServer()
+	if err := startServer(DefaultRegistryPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,74 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	uwifi "github.com/u-root/u-root/pkg/wifi"
+	"github.com/u-root/u-root/pkg/wifi/known"
+)
+
+// wifiStateFile is where the controller's current state and last transition
+// reason are published as JSON, under sosd's /var/run/sos directory. It's a
+// plain file rather than a direct API call because cmds/wifi and cmds/sos
+// are separate processes. sosd (cmds/sos) does not read or serve this file.
+const wifiStateFile = "/var/run/sos/wifi-state.json"
+
+type wifiState struct {
+	Interface string `json:"interface"`
+	State     string `json:"state"`
+	Reason    string `json:"reason"`
+}
+
+// serve replaces talking to wpa_supplicant/dhclient as one-shot shell
+// commands per request: it owns a single long-lived Controller for iface
+// and feeds it from ConnectReqChan/RefreshReqChan instead of spawning a new
+// wpa_supplicant for every connect attempt.
+func serve(iface, knownPath string) {
+	ctrl := uwifi.NewController(iface)
+	publishState(ctrl)
+
+	for {
+		select {
+		case req := <-ConnectReqChan:
+			args := []string{req.essid}
+			if store, err := known.Load(knownPath); err == nil {
+				if entry, ok := store.Match(req.essid); ok {
+					args = entry.Args()
+				}
+			}
+			err := ctrl.Connect(args...)
+			req.success = err == nil
+			publishState(ctrl)
+			if req.c != nil {
+				req.c <- err
+			}
+		case req := <-RefreshReqChan:
+			publishState(ctrl)
+			if req.c != nil {
+				req.c <- nil
+			}
+		}
+	}
+}
+
+func publishState(ctrl *uwifi.Controller) {
+	state, reason := ctrl.State()
+	b, err := json.Marshal(wifiState{
+		Interface: ctrl.Interface,
+		State:     state.String(),
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Printf("publish wifi state: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(wifiStateFile, b, 0644); err != nil {
+		log.Printf("publish wifi state: %v", err)
+	}
+}
@@ -13,18 +13,23 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/u-root/u-root/pkg/dhclient"
+	uwifi "github.com/u-root/u-root/pkg/wifi"
+	"github.com/u-root/u-root/pkg/wifi/known"
 	"github.com/u-root/u-root/pkg/wpa/passphrase"
 	"github.com/vishvananda/netlink"
 )
 
 const (
-	cmd          = "wifi [options] essid [passphrase] [identity]"
-	nopassphrase = `network={
+	cmd                      = "wifi [options] essid [passphrase] [identity]"
+	defaultKnownNetworksFile = ".wifi/known.yaml"
+	nopassphrase             = `network={
 		ssid="%s"
 		proto=RSN
 		key_mgmt=NONE
@@ -47,14 +52,29 @@ func init() {
 
 func main() {
 	var (
-		iface = flag.String("i", "wlan0", "interface to use")
-		essid string
-		conf  []byte
+		iface     = flag.String("i", "wlan0", "interface to use")
+		auto      = flag.Bool("auto", false, "scan for known networks, strongest signal first, and connect to the first one that succeeds")
+		daemon    = flag.Bool("d", false, "run as a daemon, taking connect/refresh requests over ConnectReqChan/RefreshReqChan")
+		knownPath = flag.String("known", defaultKnownNetworksFile, "path to the known-networks file, relative to $HOME unless absolute")
+		essid     string
+		conf      []byte
 	)
 
 	flag.Parse()
 	a := flag.Args()
 
+	if *daemon {
+		serve(*iface, knownNetworksPath(*knownPath))
+		return
+	}
+
+	if *auto {
+		if err := autoConnect(*iface, knownNetworksPath(*knownPath)); err != nil {
+			log.Fatalf("auto: %v", err)
+		}
+		return
+	}
+
 	switch {
 	case len(a) == 3:
 		essid = a[0]
@@ -203,3 +223,63 @@ func dhclient4(iface netlink.Link) error {
 	}
 	return nil
 }
+
+// knownNetworksPath resolves p against $HOME when it isn't already
+// absolute, so the default "-known" value behaves like "~/.wifi/known.yaml"
+// without us having to do our own "~" expansion.
+func knownNetworksPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "/"
+	}
+	return filepath.Join(home, p)
+}
+
+// autoConnect scans iface for nearby networks, ranks them strongest-signal
+// first, and tries each one we have credentials for in knownPath until one
+// connects. This is the headless "roam to a known AP on boot" mode;
+// wpa_supplicant/dhclient failures on one candidate just move on to the
+// next instead of giving up after the first attempt.
+func autoConnect(iface, knownPath string) error {
+	store, err := known.Load(knownPath)
+	if err != nil {
+		return err
+	}
+
+	worker, err := uwifi.NewWorker(iface)
+	if err != nil {
+		return err
+	}
+
+	opts, err := worker.ScanWifi()
+	if err != nil {
+		return fmt.Errorf("scan %v: %v", iface, err)
+	}
+	sort.Slice(opts, func(i, j int) bool {
+		return opts[i].Signal > opts[j].Signal
+	})
+
+	var lastErr error
+	tried := 0
+	for _, o := range opts {
+		entry, ok := store.Match(o.Essid)
+		if !ok {
+			continue
+		}
+		tried++
+		log.Printf("auto: trying %v (%v dBm)", entry.Essid, o.Signal)
+		if err := worker.Connect(entry.Args()...); err != nil {
+			log.Printf("auto: %v: %v", entry.Essid, err)
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	if tried == 0 {
+		return fmt.Errorf("no known networks in range")
+	}
+	return fmt.Errorf("all %d known networks in range failed, last error: %v", tried, lastErr)
+}
@@ -0,0 +1,396 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	wpactrl "github.com/u-root/u-root/pkg/wpa/ctrl"
+)
+
+// stateTimeout bounds how long ConnectCredentials will wait in any one
+// State for wpa_supplicant (or dhclient) to move it along, so a candidate
+// that never reaches a terminal event -- an AP that goes out of range
+// mid-associate, a DHCP server that never answers, a wedged dhclient --
+// fails the attempt instead of hanging autoConnect's retry loop forever.
+const stateTimeout = 30 * time.Second
+
+// State is a node in the wifi association lifecycle, loosely modeled on
+// Fuchsia's wlan state machine. Connect used to be a pair of fire-and-forget
+// goroutines racing a 30-second timer; this makes each step observable so
+// callers (and SosService, for a UI) can tell a wrong password apart from a
+// slow DHCP server instead of just seeing "Connection Timeout" either way.
+type State int
+
+const (
+	StateUnassociated State = iota
+	StateScanning
+	StateAssociating
+	StateAuthenticating
+	StateEAPOLHandshake
+	StateDHCP
+	StateAssociated
+	StateDisconnected
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUnassociated:
+		return "unassociated"
+	case StateScanning:
+		return "scanning"
+	case StateAssociating:
+		return "associating"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateEAPOLHandshake:
+		return "eapol-handshake"
+	case StateDHCP:
+		return "dhcp"
+	case StateAssociated:
+		return "associated"
+	case StateDisconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// EventKind identifies where an Event originated.
+type EventKind int
+
+const (
+	// EventWpaMessage carries a message observed over the wpa_supplicant
+	// control socket, either an unsolicited event (Name is e.g.
+	// "CTRL-EVENT-SSID-TEMP-DISABLED", Params is its key=value pairs) or,
+	// for messages we only pattern-match on today, the raw log line.
+	EventWpaMessage EventKind = iota
+	// EventLinkUp/EventLinkDown come from netlink link-state updates.
+	EventLinkUp
+	EventLinkDown
+	// EventDHCPDone/EventDHCPFailed come from the DHCP client.
+	EventDHCPDone
+	EventDHCPFailed
+)
+
+// Event is one input to the controller's state machine.
+type Event struct {
+	Kind    EventKind
+	Message string
+	Params  map[string]string
+	Err     error
+}
+
+// stateHandler implements one State's behavior: enter runs once on
+// transition in (e.g. "start scanning", "launch dhclient"), handleEvent
+// decides what Event moves us to what next State.
+type stateHandler interface {
+	enter(c *Controller) error
+	handleEvent(c *Controller, evt Event) State
+}
+
+// Controller drives a single interface through the wifi lifecycle. It
+// replaces the old WifiWorker.Connect, which blocked on dhclient with a
+// blind 30-second timeout and had no way to observe wpa_supplicant
+// rejecting a bad passphrase.
+type Controller struct {
+	Interface string
+
+	mu     sync.Mutex
+	state  State
+	reason string
+
+	events chan Event
+	wpa    *wpactrl.Conn
+}
+
+func NewController(iface string) *Controller {
+	return &Controller{
+		Interface: iface,
+		state:     StateUnassociated,
+		events:    make(chan Event, 16),
+	}
+}
+
+// State returns the controller's current state and the reason for its last
+// transition, for SosService (or any other UI) to report.
+func (c *Controller) State() (State, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state, c.reason
+}
+
+func (c *Controller) setState(s State, reason string) {
+	c.mu.Lock()
+	c.state, c.reason = s, reason
+	c.mu.Unlock()
+}
+
+var stateHandlers = map[State]stateHandler{
+	StateScanning:       scanningState{},
+	StateAssociating:    associatingState{},
+	StateAuthenticating: authenticatingState{},
+	StateEAPOLHandshake: eapolHandshakeState{},
+	StateDHCP:           dhcpState{},
+}
+
+// Connect is the deprecated positional-argument form of ConnectCredentials,
+// kept only so existing callers (the wifi CLI chief among them) don't break.
+// New code should build a Credentials value and call ConnectCredentials
+// instead, since the positional form can't express WPA3-SAE, EAP methods
+// other than PEAP/MSCHAPv2, or certificate-based EAP-TLS.
+//
+// Deprecated: use ConnectCredentials.
+func (c *Controller) Connect(creds ...string) error {
+	if len(creds) == 0 {
+		err := fmt.Errorf("Connect needs at least an essid")
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+	cr, err := credentialsFromArgs(creds)
+	if err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+	return c.ConnectCredentials(cr)
+}
+
+// ConnectCredentials drives the controller from Unassociated through to
+// Associated (or back to Disconnected on failure). It supervises
+// wpa_supplicant over its control socket via pkg/wpa/ctrl instead of
+// writing a throwaway config file and respawning wpa_supplicant per
+// attempt: if a supplicant is already running on c.Interface,
+// ConnectCredentials reuses it and just pushes a new network block.
+func (c *Controller) ConnectCredentials(cr Credentials) error {
+	c.setState(StateScanning, "ensuring wpa_supplicant is running")
+	if err := stateHandlers[StateScanning].enter(c); err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+
+	wpa, err := wpactrl.Dial(wpactrl.DefaultCtrlDir, c.Interface)
+	if err != nil {
+		c.setState(StateDisconnected, fmt.Sprintf("wpa_supplicant control socket: %v", err))
+		return err
+	}
+	c.wpa = wpa
+	defer wpa.Close()
+
+	id, err := cr.configure(wpa)
+	if err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+	if err := wpa.EnableNetwork(id); err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+	if err := wpa.SelectNetwork(id); err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+	if err := wpa.Attach(); err != nil {
+		c.setState(StateDisconnected, err.Error())
+		return err
+	}
+
+	rawEvents := make(chan wpactrl.Event, 16)
+	go func() {
+		defer close(rawEvents)
+		wpa.Monitor(rawEvents)
+	}()
+	go translateEvents(rawEvents, c.events)
+
+	c.setState(StateAssociating, "network selected")
+	timeout := time.NewTimer(stateTimeout)
+	defer timeout.Stop()
+	for {
+		var evt Event
+		select {
+		case evt = <-c.events:
+		case <-timeout.C:
+			cur, _ := c.State()
+			reason := fmt.Sprintf("timed out waiting in state %v", cur)
+			c.setState(StateDisconnected, reason)
+			return fmt.Errorf("connect %v: %v", c.Interface, reason)
+		}
+		cur, _ := c.State()
+		h, ok := stateHandlers[cur]
+		if !ok {
+			continue
+		}
+		next := h.handleEvent(c, evt)
+		if next == cur {
+			continue
+		}
+		if !timeout.Stop() {
+			<-timeout.C
+		}
+		timeout.Reset(stateTimeout)
+		reason := evt.Message
+		if r, ok := evt.Params["reason"]; ok && r != "" {
+			reason = fmt.Sprintf("%s (%s)", reason, r)
+		}
+		if evt.Err != nil {
+			reason = evt.Err.Error()
+		}
+		c.setState(next, reason)
+		switch next {
+		case StateAssociated:
+			return nil
+		case StateDisconnected:
+			return fmt.Errorf("connect %v: %v", c.Interface, reason)
+		}
+		if handler, ok := stateHandlers[next]; ok {
+			if err := handler.enter(c); err != nil {
+				c.setState(StateDisconnected, err.Error())
+				return err
+			}
+		}
+	}
+}
+
+// translateEvents turns raw wpa_supplicant events into controller Events.
+// parseEvent sets Name to the line's first field for every non-blank line,
+// not just CTRL-EVENT-*/WPS-* tokens, so a plain info line like "Associated
+// with 00:11:22:33:44:55" would otherwise arrive truncated to "Associated"
+// -- breaking the substring matches the state handlers below do against
+// those info messages. Only use the short Name for the known token-style
+// events; everything else keeps the full Raw line.
+func translateEvents(in <-chan wpactrl.Event, out chan<- Event) {
+	for evt := range in {
+		msg := evt.Raw
+		if strings.HasPrefix(evt.Name, "CTRL-EVENT-") || strings.HasPrefix(evt.Name, "WPS-") {
+			msg = evt.Name
+		}
+		out <- Event{Kind: EventWpaMessage, Message: msg, Params: evt.Params}
+	}
+}
+
+type scanningState struct{}
+
+// enter makes sure a wpa_supplicant is running on the interface, starting
+// one if needed, but never respawns an already-running one -- Connect may
+// be called many times as the caller cycles through candidate networks.
+func (scanningState) enter(c *Controller) error {
+	return ensureSupplicant(c.Interface)
+}
+
+func (scanningState) handleEvent(c *Controller, evt Event) State {
+	return StateAssociating
+}
+
+type associatingState struct{}
+
+func (associatingState) enter(c *Controller) error { return nil }
+
+func (associatingState) handleEvent(c *Controller, evt Event) State {
+	switch {
+	case evt.Message == wpactrl.EventSSIDTempDisabled:
+		// wrong passphrase/identity: wpa_supplicant gives up on this
+		// network rather than retrying forever. Surface wpa_supplicant's
+		// own reason (WRONG_KEY, CONN_FAILED, ...) instead of a blind
+		// timeout.
+		return StateDisconnected
+	case strings.Contains(evt.Message, "Associated with"):
+		return StateAuthenticating
+	default:
+		return StateAssociating
+	}
+}
+
+type authenticatingState struct{}
+
+func (authenticatingState) enter(c *Controller) error { return nil }
+
+func (authenticatingState) handleEvent(c *Controller, evt Event) State {
+	switch {
+	case evt.Message == wpactrl.EventSSIDTempDisabled, evt.Message == wpactrl.EventEAPFailure:
+		return StateDisconnected
+	case strings.Contains(evt.Message, "Key negotiation completed"):
+		return StateEAPOLHandshake
+	default:
+		return StateAuthenticating
+	}
+}
+
+type eapolHandshakeState struct{}
+
+func (eapolHandshakeState) enter(c *Controller) error { return nil }
+
+func (eapolHandshakeState) handleEvent(c *Controller, evt Event) State {
+	if evt.Message == wpactrl.EventConnected {
+		return StateDHCP
+	}
+	return StateEAPOLHandshake
+}
+
+// dhcpState's enter runs dhclient in the background (bounded by
+// stateTimeout) and feeds its outcome back to the controller as
+// EventDHCPDone/EventDHCPFailed, which handleEvent turns into Associated or
+// Disconnected.
+type dhcpState struct{}
+
+func (dhcpState) enter(c *Controller) error {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "dhclient", "-ipv4=true", "-ipv6=false", c.Interface)
+		err := cmd.Run()
+		if err != nil {
+			c.events <- Event{Kind: EventDHCPFailed, Err: err}
+			return
+		}
+		c.events <- Event{Kind: EventDHCPDone}
+	}()
+	return nil
+}
+
+func (dhcpState) handleEvent(c *Controller, evt Event) State {
+	switch evt.Kind {
+	case EventDHCPDone:
+		return StateAssociated
+	case EventDHCPFailed:
+		return StateDisconnected
+	default:
+		return StateDHCP
+	}
+}
+
+// ensureSupplicant starts wpa_supplicant on iface if its control socket
+// doesn't already exist. Unlike the old Connect, this means a second
+// Connect call against the same interface reuses the running daemon
+// instead of spawning a new one (and overwriting /tmp/wifi.conf out from
+// under it).
+func ensureSupplicant(iface string) error {
+	sock := wpactrl.DefaultCtrlDir + "/" + iface
+	if _, err := os.Stat(sock); err == nil {
+		return nil
+	}
+
+	if err := exec.Command("ip", "link", "set", "dev", iface, "up").Run(); err != nil {
+		return fmt.Errorf("ip link set dev %v up: %v", iface, err)
+	}
+
+	cmd := exec.Command("wpa_supplicant", "-i"+iface, "-C"+wpactrl.DefaultCtrlDir)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start wpa_supplicant: %v", err)
+	}
+	// Give wpa_supplicant a moment to create its control socket before we
+	// try to dial it.
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("wpa_supplicant did not create %v", sock)
+}
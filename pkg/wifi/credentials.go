@@ -0,0 +1,196 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"fmt"
+
+	wpactrl "github.com/u-root/u-root/pkg/wpa/ctrl"
+)
+
+// EAPMethod is a wpa_supplicant `eap=` method for WPA-Enterprise networks.
+type EAPMethod string
+
+const (
+	EAPPEAP EAPMethod = "PEAP"
+	EAPTTLS EAPMethod = "TTLS"
+	EAPTLS  EAPMethod = "TLS"
+	EAPPWD  EAPMethod = "PWD"
+)
+
+// Phase2Auth is the inner authentication method tunneled inside PEAP/TTLS.
+type Phase2Auth string
+
+const (
+	Phase2MSCHAPV2 Phase2Auth = "MSCHAPV2"
+	Phase2PAP      Phase2Auth = "PAP"
+	Phase2GTC      Phase2Auth = "GTC"
+)
+
+// Credentials fully describes how to join one network, replacing the old
+// positional [essid], [essid, pass], [essid, pass, id] arguments, which
+// could only express an open network, WPA2-Personal, or bare PEAP/MSCHAPv2
+// WPA-Enterprise -- nothing else real deployments use (WPA3-SAE, EAP-TLS,
+// EAP-TTLS, a SAE transition network advertising both WPA2 and WPA3, ...).
+type Credentials struct {
+	Essid string
+
+	// Security is the AKM suite bitmask to offer wpa_supplicant, e.g.
+	// AuthNone for an open network, AuthPSK for WPA2-Personal,
+	// AuthPSK|AuthSAE for a WPA2/WPA3-SAE transition network, AuthSAE
+	// alone for SAE-only, or Auth8021X for WPA-Enterprise.
+	Security AuthSuite
+
+	// Passphrase is the WPA2/WPA3-Personal pre-shared key. Unused for
+	// Auth8021X.
+	Passphrase string
+
+	// The remaining fields only apply when Security includes Auth8021X.
+	EAPMethod         EAPMethod
+	Phase2            Phase2Auth
+	Identity          string
+	AnonymousIdentity string
+	Password          string
+	CACert            string
+	ClientCert        string
+	PrivateKey        string
+	PrivateKeyPasswd  string
+}
+
+// configure pushes cr as a new wpa_supplicant network block via SET_NETWORK
+// calls and returns its network id. This is the typed replacement for the
+// old generateConfig text templates (nopassphrase/eap), now emitting the
+// values over the control socket instead of a static config file.
+func (cr Credentials) configure(wpa *wpactrl.Conn) (int, error) {
+	id, err := wpa.AddNetwork()
+	if err != nil {
+		return 0, fmt.Errorf("add network: %v", err)
+	}
+	set := func(variable, value string) error {
+		if err := wpa.SetNetwork(id, variable, value); err != nil {
+			return fmt.Errorf("set %v: %v", variable, err)
+		}
+		return nil
+	}
+
+	if err := set("ssid", wpactrl.QuoteString(cr.Essid)); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case cr.Security == AuthNone:
+		if err := set("key_mgmt", "NONE"); err != nil {
+			return 0, err
+		}
+
+	case cr.Security&Auth8021X != 0:
+		if err := cr.configureEAP(set); err != nil {
+			return 0, err
+		}
+
+	default:
+		if err := cr.configurePersonal(set); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// configurePersonal handles WPA2-PSK, WPA3-SAE, and SAE-transition
+// (PSK+SAE advertised together so both WPA2- and WPA3-only clients can
+// join).
+func (cr Credentials) configurePersonal(set func(string, string) error) error {
+	var keyMgmt string
+	switch {
+	case cr.Security&AuthPSK != 0 && cr.Security&AuthSAE != 0:
+		keyMgmt = "SAE WPA-PSK"
+	case cr.Security&AuthSAE != 0:
+		keyMgmt = "SAE"
+	default:
+		keyMgmt = "WPA-PSK"
+	}
+	if err := set("key_mgmt", keyMgmt); err != nil {
+		return err
+	}
+	return set("psk", wpactrl.QuoteString(cr.Passphrase))
+}
+
+// configureEAP handles WPA-Enterprise, including EAP-TLS client
+// certificates, which have no passphrase at all.
+func (cr Credentials) configureEAP(set func(string, string) error) error {
+	if err := set("key_mgmt", "WPA-EAP"); err != nil {
+		return err
+	}
+	if cr.EAPMethod != "" {
+		if err := set("eap", string(cr.EAPMethod)); err != nil {
+			return err
+		}
+	}
+	if cr.Identity != "" {
+		if err := set("identity", wpactrl.QuoteString(cr.Identity)); err != nil {
+			return err
+		}
+	}
+	if cr.AnonymousIdentity != "" {
+		if err := set("anonymous_identity", wpactrl.QuoteString(cr.AnonymousIdentity)); err != nil {
+			return err
+		}
+	}
+	if cr.Password != "" {
+		if err := set("password", wpactrl.QuoteString(cr.Password)); err != nil {
+			return err
+		}
+	}
+	if cr.Phase2 != "" {
+		if err := set("phase2", wpactrl.QuoteString("auth="+string(cr.Phase2))); err != nil {
+			return err
+		}
+	}
+	if cr.CACert != "" {
+		if err := set("ca_cert", wpactrl.QuoteString(cr.CACert)); err != nil {
+			return err
+		}
+	}
+	if cr.ClientCert != "" {
+		if err := set("client_cert", wpactrl.QuoteString(cr.ClientCert)); err != nil {
+			return err
+		}
+	}
+	if cr.PrivateKey != "" {
+		if err := set("private_key", wpactrl.QuoteString(cr.PrivateKey)); err != nil {
+			return err
+		}
+	}
+	if cr.PrivateKeyPasswd != "" {
+		if err := set("private_key_passwd", wpactrl.QuoteString(cr.PrivateKeyPasswd)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// credentialsFromArgs converts the deprecated [essid], [essid, pass],
+// [essid, pass, id] positional form into Credentials, for Connect's
+// backward-compatibility shim.
+func credentialsFromArgs(a []string) (Credentials, error) {
+	switch len(a) {
+	case 1:
+		return Credentials{Essid: a[0], Security: AuthNone}, nil
+	case 2:
+		return Credentials{Essid: a[0], Security: AuthPSK, Passphrase: a[1]}, nil
+	case 3:
+		return Credentials{
+			Essid:     a[0],
+			Security:  Auth8021X,
+			EAPMethod: EAPPEAP,
+			Phase2:    Phase2MSCHAPV2,
+			Identity:  a[2],
+			Password:  a[1],
+		}, nil
+	default:
+		return Credentials{}, fmt.Errorf("need 1 (essid), 2 (essid, passphrase), or 3 (essid, password, identity) args, got %d", len(a))
+	}
+}
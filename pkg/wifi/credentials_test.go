@@ -0,0 +1,60 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCredentialsFromArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want Credentials
+	}{
+		{
+			name: "essid only",
+			args: []string{"open-net"},
+			want: Credentials{Essid: "open-net", Security: AuthNone},
+		},
+		{
+			name: "essid and passphrase",
+			args: []string{"home", "hunter2"},
+			want: Credentials{Essid: "home", Security: AuthPSK, Passphrase: "hunter2"},
+		},
+		{
+			name: "essid, password, identity",
+			args: []string{"corp", "swordfish", "alice"},
+			want: Credentials{
+				Essid:     "corp",
+				Security:  Auth8021X,
+				EAPMethod: EAPPEAP,
+				Phase2:    Phase2MSCHAPV2,
+				Identity:  "alice",
+				Password:  "swordfish",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := credentialsFromArgs(tt.args)
+			if err != nil {
+				t.Fatalf("credentialsFromArgs(%v): %v", tt.args, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("credentialsFromArgs(%v) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialsFromArgsErrors(t *testing.T) {
+	for _, args := range [][]string{nil, {}, {"a", "b", "c", "d"}} {
+		if _, err := credentialsFromArgs(args); err == nil {
+			t.Errorf("credentialsFromArgs(%v): want error, got nil", args)
+		}
+	}
+}
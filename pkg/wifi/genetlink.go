@@ -0,0 +1,289 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file implements just enough of generic netlink (genetlink) to talk to
+// the kernel's nl80211 family: resolving the family id by name, and sending
+// requests/receiving (possibly multi-part) dump replies. It deliberately
+// does not pull in a full netlink library; nl80211 is the only genetlink
+// family we speak.
+
+const (
+	genlCtrlName    = "nlctrl"
+	genlIDCtrl      = unix.GENL_ID_CTRL
+	ctrlCmdGetFam   = 3
+	ctrlAttrFamID   = 1
+	ctrlAttrFamName = 2
+
+	ctrlAttrMcastGroups  = 7
+	ctrlAttrMcastGrpName = 1
+	ctrlAttrMcastGrpID   = 2
+)
+
+// genlConn is a NETLINK_GENERIC socket.
+type genlConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialGenl() (*genlConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %v", err)
+	}
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %v", err)
+	}
+	return &genlConn{fd: fd}, nil
+}
+
+func (c *genlConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// execute sends a genetlink message (family, cmd, version, attrs already
+// encoded) and returns the concatenated payloads of every reply message,
+// following NLM_F_MULTI until NLMSG_DONE.
+func (c *genlConn) execute(family uint16, cmd, version uint8, flags uint16, attrs []byte) ([][]byte, error) {
+	c.seq++
+	seq := c.seq
+
+	payload := append([]byte{cmd, version, 0, 0}, attrs...)
+	msg := nlMsg(family, flags|unix.NLM_F_REQUEST, seq, payload)
+
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink sendto: %v", err)
+	}
+
+	var out [][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("netlink recvfrom: %v", err)
+		}
+		msgs, done, err := parseNlMsgs(buf[:n], seq)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, msgs...)
+		if done {
+			break
+		}
+	}
+	return out, nil
+}
+
+// nlMsg wraps payload in an nlmsghdr. genetlink type field doubles as the
+// resolved family id.
+func nlMsg(typ uint16, flags uint16, seq uint32, payload []byte) []byte {
+	const hdrLen = 16
+	total := align4(hdrLen + len(payload))
+	b := make([]byte, total)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(hdrLen+len(payload)))
+	binary.LittleEndian.PutUint16(b[4:6], typ)
+	binary.LittleEndian.PutUint16(b[6:8], flags)
+	binary.LittleEndian.PutUint32(b[8:12], seq)
+	binary.LittleEndian.PutUint32(b[12:16], uint32(unix.Getpid()))
+	copy(b[hdrLen:], payload)
+	return b
+}
+
+// parseNlMsgs walks every nlmsghdr in b that matches seq, returning the
+// genetlink payload (cmd+version+attrs) of each, plus whether NLMSG_DONE or
+// a non-multipart message ended the stream.
+func parseNlMsgs(b []byte, seq uint32) (out [][]byte, done bool, err error) {
+	for len(b) >= 16 {
+		length := binary.LittleEndian.Uint32(b[0:4])
+		typ := binary.LittleEndian.Uint16(b[4:6])
+		flags := binary.LittleEndian.Uint16(b[6:8])
+		rseq := binary.LittleEndian.Uint32(b[8:12])
+		if int(length) > len(b) || length < 16 {
+			return out, true, fmt.Errorf("netlink: malformed message")
+		}
+		if rseq == seq {
+			switch typ {
+			case unix.NLMSG_DONE:
+				return out, true, nil
+			case unix.NLMSG_ERROR:
+				errno := int32(binary.LittleEndian.Uint32(b[16:20]))
+				if errno != 0 {
+					return out, true, fmt.Errorf("netlink: %v", unix.Errno(-errno))
+				}
+				return out, true, nil
+			default:
+				out = append(out, b[16:length])
+				if flags&unix.NLM_F_MULTI == 0 {
+					done = true
+				}
+			}
+		}
+		b = b[align4(int(length)):]
+	}
+	return out, done, nil
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// resolveFamily asks the kernel's nlctrl family for the numeric genetlink
+// family id of name (e.g. "nl80211").
+func (c *genlConn) resolveFamily(name string) (uint16, error) {
+	attrs := encodeAttrString(ctrlAttrFamName, name)
+	msgs, err := c.execute(genlIDCtrl, ctrlCmdGetFam, 1, 0, attrs)
+	if err != nil {
+		return 0, fmt.Errorf("resolve family %v: %v", name, err)
+	}
+	for _, m := range msgs {
+		for _, a := range parseAttrs(m[4:]) {
+			if a.Type == ctrlAttrFamID {
+				return binary.LittleEndian.Uint16(a.Data), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("family %v not found", name)
+}
+
+// resolveMcastGroup asks the kernel's nlctrl family for the numeric
+// multicast group id of group within family (e.g. nl80211's "scan" group,
+// which ScanWifi joins to learn when a triggered scan has actually
+// finished).
+func (c *genlConn) resolveMcastGroup(family, group string) (uint32, error) {
+	attrs := encodeAttrString(ctrlAttrFamName, family)
+	msgs, err := c.execute(genlIDCtrl, ctrlCmdGetFam, 1, 0, attrs)
+	if err != nil {
+		return 0, fmt.Errorf("resolve multicast group %v/%v: %v", family, group, err)
+	}
+	for _, m := range msgs {
+		for _, a := range parseAttrs(m[4:]) {
+			if a.Type != ctrlAttrMcastGroups {
+				continue
+			}
+			for _, grp := range parseAttrs(a.Data) {
+				var name string
+				var id uint32
+				var hasID bool
+				for _, f := range parseAttrs(grp.Data) {
+					switch f.Type {
+					case ctrlAttrMcastGrpName:
+						name = cString(f.Data)
+					case ctrlAttrMcastGrpID:
+						if len(f.Data) >= 4 {
+							id = binary.LittleEndian.Uint32(f.Data)
+							hasID = true
+						}
+					}
+				}
+				if hasID && name == group {
+					return id, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("multicast group %v/%v not found", family, group)
+}
+
+// joinMulticastGroup subscribes this socket to unsolicited genetlink
+// notifications for the given (already-resolved) multicast group id.
+func (c *genlConn) joinMulticastGroup(id uint32) error {
+	return unix.SetsockoptInt(c.fd, unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, int(id))
+}
+
+// setReadTimeout bounds how long the next Recvfrom on this socket may block,
+// so a caller waiting on multicast notifications (e.g. for a scan to finish)
+// doesn't hang forever if the kernel never sends one.
+func (c *genlConn) setReadTimeout(d time.Duration) error {
+	tv := unix.NsecToTimeval(d.Nanoseconds())
+	return unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+}
+
+// recvOne reads a single netlink datagram off the socket. ok is true only
+// when it's an unsolicited genetlink message for family, in which case cmd
+// and (if present) NL80211_ATTR_IFINDEX are returned; a message for some
+// other family, or one too short to carry a genetlink header, comes back as
+// ok == false so the caller can just keep reading. An nlmsghdr whose length
+// doesn't fit the datagram is returned as an error.
+func (c *genlConn) recvOne(family uint16) (cmd uint8, ifindex uint32, ok bool, err error) {
+	buf := make([]byte, 1<<16)
+	n, _, err := unix.Recvfrom(c.fd, buf, 0)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	b := buf[:n]
+	for len(b) >= 16 {
+		length := binary.LittleEndian.Uint32(b[0:4])
+		typ := binary.LittleEndian.Uint16(b[4:6])
+		if int(length) > len(b) || length < 16 {
+			return 0, 0, false, fmt.Errorf("netlink: malformed message")
+		}
+		if typ == family {
+			payload := b[16:length]
+			if len(payload) < 4 {
+				return 0, 0, false, nil
+			}
+			cmd = payload[0]
+			for _, a := range parseAttrs(payload[4:]) {
+				if a.Type == nl80211AttrIfindex && len(a.Data) >= 4 {
+					ifindex = binary.LittleEndian.Uint32(a.Data)
+				}
+			}
+			return cmd, ifindex, true, nil
+		}
+		b = b[align4(int(length)):]
+	}
+	return 0, 0, false, nil
+}
+
+// nlAttr is one (possibly nested) netlink attribute.
+type nlAttr struct {
+	Type uint16
+	Data []byte
+}
+
+// parseAttrs walks a flat run of nlattrs (4-byte len/type header, data
+// padded to 4 bytes).
+func parseAttrs(b []byte) []nlAttr {
+	var attrs []nlAttr
+	for len(b) >= 4 {
+		l := binary.LittleEndian.Uint16(b[0:2])
+		if int(l) < 4 || int(l) > len(b) {
+			break
+		}
+		typ := binary.LittleEndian.Uint16(b[2:4]) &^ 0x8000 // strip NLA_F_NESTED
+		attrs = append(attrs, nlAttr{Type: typ, Data: b[4:l]})
+		b = b[align4(int(l)):]
+	}
+	return attrs
+}
+
+func encodeAttr(typ uint16, data []byte) []byte {
+	l := 4 + len(data)
+	b := make([]byte, align4(l))
+	binary.LittleEndian.PutUint16(b[0:2], uint16(l))
+	binary.LittleEndian.PutUint16(b[2:4], typ)
+	copy(b[4:], data)
+	return b
+}
+
+func encodeAttrString(typ uint16, s string) []byte {
+	return encodeAttr(typ, append([]byte(s), 0))
+}
+
+func encodeAttrU32(typ uint16, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return encodeAttr(typ, b)
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// rawNlMsg builds one nlmsghdr + payload, padded to a 4-byte boundary, the
+// same layout nlMsg produces.
+func rawNlMsg(typ uint16, flags uint16, seq uint32, payload []byte) []byte {
+	const hdrLen = 16
+	total := align4(hdrLen + len(payload))
+	b := make([]byte, total)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(hdrLen+len(payload)))
+	binary.LittleEndian.PutUint16(b[4:6], typ)
+	binary.LittleEndian.PutUint16(b[6:8], flags)
+	binary.LittleEndian.PutUint32(b[8:12], seq)
+	copy(b[hdrLen:], payload)
+	return b
+}
+
+func TestParseNlMsgsIgnoresUnrelatedMessages(t *testing.T) {
+	// execute() calls parseNlMsgs once per Recvfrom; a stray, non-multipart
+	// multicast notification (e.g. nl80211's "scan" group) landing in its
+	// own read ahead of our dump reply must not look like the end of our
+	// reply stream just because it happened to carry NLM_F_MULTI unset.
+	stray := rawNlMsg(0x13, 0, 999, []byte{1, 2, 3, 4})
+	_, strayDone, err := parseNlMsgs(stray, 42)
+	if err != nil {
+		t.Fatalf("parseNlMsgs(stray): %v", err)
+	}
+	if strayDone {
+		t.Fatalf("parseNlMsgs(stray): done = true, want false (unrelated seq must not end our read loop)")
+	}
+
+	reply := rawNlMsg(0x13, unix.NLM_F_MULTI, 42, []byte{5, 6, 7, 8})
+	out, replyDone, err := parseNlMsgs(reply, 42)
+	if err != nil {
+		t.Fatalf("parseNlMsgs(reply): %v", err)
+	}
+	if replyDone {
+		t.Fatalf("parseNlMsgs(reply): done = true, want false (NLM_F_MULTI reply awaits NLMSG_DONE)")
+	}
+	if len(out) != 1 {
+		t.Fatalf("parseNlMsgs(reply): got %d messages, want 1", len(out))
+	}
+
+	done := rawNlMsg(unix.NLMSG_DONE, unix.NLM_F_MULTI, 42, nil)
+	out, isDone, err := parseNlMsgs(done, 42)
+	if err != nil {
+		t.Fatalf("parseNlMsgs(done): %v", err)
+	}
+	if !isDone {
+		t.Fatalf("parseNlMsgs(done): done = false, want true")
+	}
+	if len(out) != 0 {
+		t.Fatalf("parseNlMsgs(done): got %d messages, want 0", len(out))
+	}
+}
+
+func TestParseNlMsgsSingleNonMultiReply(t *testing.T) {
+	reply := rawNlMsg(0x13, 0, 7, []byte{9, 9})
+	out, done, err := parseNlMsgs(reply, 7)
+	if err != nil {
+		t.Fatalf("parseNlMsgs: %v", err)
+	}
+	if !done {
+		t.Fatalf("parseNlMsgs: done = false, want true for a non-multipart matching reply")
+	}
+	if len(out) != 1 {
+		t.Fatalf("parseNlMsgs: got %d messages, want 1", len(out))
+	}
+}
+
+func TestParseNlMsgsError(t *testing.T) {
+	errPayload := make([]byte, 4)
+	errno := int32(unix.ENOENT)
+	binary.LittleEndian.PutUint32(errPayload, uint32(-errno))
+	msg := rawNlMsg(unix.NLMSG_ERROR, 0, 1, errPayload)
+	_, _, err := parseNlMsgs(msg, 1)
+	if err == nil {
+		t.Fatal("parseNlMsgs: want error for NLMSG_ERROR with nonzero errno")
+	}
+}
+
+func TestParseAttrsRoundTrip(t *testing.T) {
+	b := append(encodeAttrU32(nl80211AttrIfindex, 42), encodeAttrString(ctrlAttrFamName, "nl80211")...)
+	attrs := parseAttrs(b)
+	if len(attrs) != 2 {
+		t.Fatalf("parseAttrs: got %d attrs, want 2", len(attrs))
+	}
+	if attrs[0].Type != nl80211AttrIfindex {
+		t.Errorf("attrs[0].Type = %v, want %v", attrs[0].Type, nl80211AttrIfindex)
+	}
+	if got := cString(attrs[1].Data); got != "nl80211" {
+		t.Errorf("attrs[1].Data = %q, want %q", got, "nl80211")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import "encoding/binary"
+
+// 802.11 information element IDs we care about.
+const (
+	ieSSID = 0
+	ieRSN  = 48
+	ieVendor = 221
+)
+
+// OUI + type for the WPA1 vendor-specific IE (Microsoft's pre-RSN draft).
+var wpa1OUI = [4]byte{0x00, 0x50, 0xf2, 0x01}
+
+// AKM suite selectors, as carried in RSN/WPA IEs: 4-byte OUI + suite type.
+// The OUI is 00-0f-ac for the standard (non-vendor) suites we support.
+const (
+	akmPSK    = 2
+	akm8021X  = 1
+	akmFTPSK  = 4
+	akmSAE    = 8
+	akmFTSAE  = 9
+	akmOWE    = 18
+)
+
+// parseIEs walks the raw 802.11 information elements blob returned by
+// NL80211_BSS_INFORMATION_ELEMENT and extracts the ESSID (kept as raw bytes
+// decoded as UTF-8 -- it may legitimately contain embedded nulls or non-UTF8
+// garbage, callers should not assume it's a clean identifier) and the
+// AuthSuite bitmask derived from the RSN IE (WPA2/WPA3) and/or the
+// Microsoft WPA1 vendor IE.
+func parseIEs(b []byte) (essid string, auth AuthSuite) {
+	for len(b) >= 2 {
+		id, length := b[0], int(b[1])
+		if 2+length > len(b) {
+			break
+		}
+		data := b[2 : 2+length]
+		switch id {
+		case ieSSID:
+			essid = string(data)
+		case ieRSN:
+			auth |= parseRSNAKMs(data)
+		case ieVendor:
+			if len(data) >= 4 && [4]byte{data[0], data[1], data[2], data[3]} == wpa1OUI {
+				auth |= AuthPSK
+			}
+		}
+		b = b[2+length:]
+	}
+	return essid, auth
+}
+
+// parseRSNAKMs decodes the AKM suite list out of an RSN IE body (version,
+// group cipher, pairwise cipher list, then AKM suite list) and returns the
+// corresponding AuthSuite bits. A BSS can list more than one AKM suite (a
+// WPA2/WPA3-SAE transition AP lists both WPA-PSK and SAE, for instance).
+func parseRSNAKMs(rsn []byte) AuthSuite {
+	// version(2) + group cipher suite(4)
+	if len(rsn) < 6 {
+		return AuthNotSupported
+	}
+	off := 6
+	if off+2 > len(rsn) {
+		return AuthNotSupported
+	}
+	pairwiseCount := int(binary.LittleEndian.Uint16(rsn[off : off+2]))
+	off += 2 + pairwiseCount*4
+	if off+2 > len(rsn) {
+		return AuthNotSupported
+	}
+	akmCount := int(binary.LittleEndian.Uint16(rsn[off : off+2]))
+	off += 2
+
+	var auth AuthSuite
+	for i := 0; i < akmCount && off+4 <= len(rsn); i++ {
+		suite := rsn[off : off+4]
+		off += 4
+		switch suite[3] {
+		case akmPSK:
+			auth |= AuthPSK
+		case akm8021X:
+			auth |= Auth8021X
+		case akmFTPSK:
+			auth |= AuthFTPSK | AuthPSK
+		case akmSAE:
+			auth |= AuthSAE
+		case akmFTSAE:
+			auth |= AuthSAE | AuthFTPSK
+		case akmOWE:
+			auth |= AuthOWE
+		default:
+			auth |= AuthNotSupported
+		}
+	}
+	if auth == 0 {
+		return AuthNotSupported
+	}
+	return auth
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import "testing"
+
+// rsnIE builds an RSN IE body: version(2) + group cipher(4) + pairwise
+// count(2) + pairwise ciphers(4 each) + akm count(2) + akm suites(4 each),
+// all little-endian, matching what parseRSNAKMs expects.
+func rsnIE(akmSuiteTypes ...byte) []byte {
+	b := []byte{1, 0, 0x00, 0x0f, 0xac, 4}   // version 1, group cipher CCMP
+	b = append(b, 1, 0, 0x00, 0x0f, 0xac, 4) // one pairwise cipher, CCMP
+	b = append(b, byte(len(akmSuiteTypes)), 0)
+	for _, t := range akmSuiteTypes {
+		b = append(b, 0x00, 0x0f, 0xac, t)
+	}
+	return b
+}
+
+func TestParseRSNAKMs(t *testing.T) {
+	tests := []struct {
+		name string
+		akms []byte
+		want AuthSuite
+	}{
+		{"psk", []byte{akmPSK}, AuthPSK},
+		{"8021x", []byte{akm8021X}, Auth8021X},
+		{"sae", []byte{akmSAE}, AuthSAE},
+		{"ft-psk", []byte{akmFTPSK}, AuthFTPSK | AuthPSK},
+		{"ft-sae", []byte{akmFTSAE}, AuthSAE | AuthFTPSK},
+		{"owe", []byte{akmOWE}, AuthOWE},
+		{"transition psk+sae", []byte{akmPSK, akmSAE}, AuthPSK | AuthSAE},
+		{"unknown", []byte{0xff}, AuthNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRSNAKMs(rsnIE(tt.akms...))
+			if got != tt.want {
+				t.Errorf("parseRSNAKMs(%v) = %v, want %v", tt.akms, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRSNAKMsTruncated(t *testing.T) {
+	if got := parseRSNAKMs([]byte{1, 2, 3}); got != AuthNotSupported {
+		t.Errorf("parseRSNAKMs(truncated) = %v, want AuthNotSupported", got)
+	}
+}
+
+func TestParseIEs(t *testing.T) {
+	var b []byte
+	b = append(b, ieSSID, 4)
+	b = append(b, []byte("home")...)
+	rsn := rsnIE(akmPSK)
+	b = append(b, ieRSN, byte(len(rsn)))
+	b = append(b, rsn...)
+
+	essid, auth := parseIEs(b)
+	if essid != "home" {
+		t.Errorf("essid = %q, want %q", essid, "home")
+	}
+	if auth != AuthPSK {
+		t.Errorf("auth = %v, want %v", auth, AuthPSK)
+	}
+}
+
+func TestParseIEsWPA1Vendor(t *testing.T) {
+	vendor := append(append([]byte{}, wpa1OUI[:]...), 1, 0, 0, 0) // OUI + type + padding
+	b := append([]byte{ieVendor, byte(len(vendor))}, vendor...)
+
+	_, auth := parseIEs(b)
+	if auth != AuthPSK {
+		t.Errorf("auth = %v, want AuthPSK (WPA1 vendor IE)", auth)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package known stores the set of wifi networks a headless u-root system
+// is allowed to auto-connect to, along with whatever credentials each one
+// needs. It's the backing store for `wifi -auto`'s preferred-network list.
+package known
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Kind identifies what sort of credentials an Entry carries.
+type Kind string
+
+const (
+	Open Kind = "open"
+	PSK  Kind = "psk"
+	EAP  Kind = "eap"
+)
+
+// Entry is one remembered network. Which fields are meaningful depends on
+// Kind: Open uses none, PSK uses Passphrase, EAP uses Identity and
+// Passphrase (as the EAP password).
+type Entry struct {
+	Essid      string `yaml:"essid"`
+	Kind       Kind   `yaml:"kind"`
+	Passphrase string `yaml:"passphrase,omitempty"`
+	Identity   string `yaml:"identity,omitempty"`
+}
+
+// Args converts e into the positional-argument form WifiWorker.Connect
+// still expects: [essid], [essid, pass], or [essid, pass, id].
+func (e Entry) Args() []string {
+	switch e.Kind {
+	case PSK:
+		return []string{e.Essid, e.Passphrase}
+	case EAP:
+		return []string{e.Essid, e.Passphrase, e.Identity}
+	default:
+		return []string{e.Essid}
+	}
+}
+
+// Store is the in-memory view of a known-networks file, normally
+// ~/.wifi/known.yaml.
+type Store struct {
+	path    string
+	Entries []Entry `yaml:"networks"`
+}
+
+// Load reads path, treating a missing file as an empty store so a system
+// with no known networks yet doesn't need one pre-created.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("known networks %v: %v", path, err)
+	}
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("known networks %v: %v", path, err)
+	}
+	return s, nil
+}
+
+// Save writes the store back to its path.
+func (s *Store) Save() error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal known networks: %v", err)
+	}
+	if err := ioutil.WriteFile(s.path, b, 0600); err != nil {
+		return fmt.Errorf("known networks %v: %v", s.path, err)
+	}
+	return nil
+}
+
+// Add remembers e, replacing any existing entry for the same ESSID.
+func (s *Store) Add(e Entry) error {
+	for i, existing := range s.Entries {
+		if existing.Essid == e.Essid {
+			s.Entries[i] = e
+			return s.Save()
+		}
+	}
+	s.Entries = append(s.Entries, e)
+	return s.Save()
+}
+
+// Remove forgets essid, if known. It's not an error to remove an unknown
+// network.
+func (s *Store) Remove(essid string) error {
+	for i, e := range s.Entries {
+		if e.Essid == essid {
+			s.Entries = append(s.Entries[:i], s.Entries[i+1:]...)
+			return s.Save()
+		}
+	}
+	return nil
+}
+
+// List returns every remembered network.
+func (s *Store) List() []Entry {
+	return s.Entries
+}
+
+// Match looks up essid in the store.
+func (s *Store) Match(essid string) (Entry, bool) {
+	for _, e := range s.Entries {
+		if e.Essid == essid {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Subset of <linux/nl80211.h> we need to trigger a scan and read back BSS
+// entries. Keep these in sync with the kernel header if new fields are
+// needed; we intentionally don't vendor the whole enum.
+const (
+	nl80211CmdGetInterface  = 5
+	nl80211CmdGetScan       = 32
+	nl80211CmdTriggerScan   = 33
+	nl80211CmdNewScanResult = 34
+	nl80211CmdScanAborted   = 35
+
+	nl80211McastGroupScan = "scan"
+	// scanTimeout bounds how long ScanWifi waits for the kernel to announce
+	// a triggered scan finished before giving up.
+	scanTimeout = 30 * time.Second
+
+	nl80211AttrIfindex = 3
+	nl80211AttrIfname  = 4
+	nl80211AttrBSS     = 47
+
+	nl80211BSSBSSID              = 1
+	nl80211BSSFrequency          = 2
+	nl80211BSSInformationElement = 6
+	nl80211BSSSignalMBM          = 7
+	nl80211BSSStatus             = 9
+
+	nl80211BSSStatusAssociated = 1
+)
+
+// nl80211Client talks to the kernel's nl80211 genetlink family to scan for
+// and enumerate wireless networks, replacing the old iwlist/iwconfig/iwgetid
+// shell-outs.
+type nl80211Client struct {
+	conn   *genlConn
+	family uint16
+}
+
+func newNl80211Client() (*nl80211Client, error) {
+	conn, err := dialGenl()
+	if err != nil {
+		return nil, err
+	}
+	family, err := conn.resolveFamily("nl80211")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Join the "scan" multicast group so ScanWifi can wait for the kernel's
+	// own NL80211_CMD_NEW_SCAN_RESULTS/SCAN_ABORTED notification instead of
+	// reading back a possibly-stale BSS table right after triggering.
+	scanGroup, err := conn.resolveMcastGroup("nl80211", nl80211McastGroupScan)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.joinMulticastGroup(scanGroup); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("join nl80211 scan multicast group: %v", err)
+	}
+	return &nl80211Client{conn: conn, family: family}, nil
+}
+
+func (n *nl80211Client) Close() error {
+	return n.conn.Close()
+}
+
+func ifindexOf(iface string) (uint32, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return 0, fmt.Errorf("no such interface %v: %v", iface, err)
+	}
+	return uint32(i.Index), nil
+}
+
+// interfaces lists every wireless interface name known to nl80211.
+func (n *nl80211Client) interfaces() ([]string, error) {
+	msgs, err := n.conn.execute(n.family, nl80211CmdGetInterface, 0, unix.NLM_F_DUMP, nil)
+	if err != nil {
+		return nil, fmt.Errorf("nl80211 get interface: %v", err)
+	}
+	var names []string
+	for _, m := range msgs {
+		for _, a := range parseAttrs(m[4:]) {
+			if a.Type == nl80211AttrIfname {
+				names = append(names, cString(a.Data))
+			}
+		}
+	}
+	return names, nil
+}
+
+// triggerScan asks the kernel to start a scan of the given interface.
+// NL80211_CMD_TRIGGER_SCAN has no inherent reply of its own -- completion is
+// announced later over the "scan" multicast group -- so this asks for a
+// bare NLM_F_ACK just to confirm the kernel accepted the request; the caller
+// still has to waitForScanDone before the BSS table is actually fresh.
+func (n *nl80211Client) triggerScan(ifindex uint32) error {
+	attrs := encodeAttrU32(nl80211AttrIfindex, ifindex)
+	_, err := n.conn.execute(n.family, nl80211CmdTriggerScan, 0, unix.NLM_F_ACK, attrs)
+	if err != nil {
+		return fmt.Errorf("nl80211 trigger scan: %v", err)
+	}
+	return nil
+}
+
+// waitForScanDone blocks until the kernel announces ifindex's scan is done,
+// via the "scan" multicast group joined in newNl80211Client, or scanTimeout
+// elapses.
+func (n *nl80211Client) waitForScanDone(ifindex uint32) error {
+	deadline := time.Now().Add(scanTimeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("timed out waiting for scan results on %v", ifindex)
+		}
+		if err := n.conn.setReadTimeout(remaining); err != nil {
+			return fmt.Errorf("set scan wait timeout: %v", err)
+		}
+		cmd, idx, ok, err := n.conn.recvOne(n.family)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EWOULDBLOCK) {
+				return fmt.Errorf("timed out waiting for scan results on %v", ifindex)
+			}
+			return fmt.Errorf("nl80211 scan notification: %v", err)
+		}
+		if !ok || idx != ifindex {
+			continue
+		}
+		switch cmd {
+		case nl80211CmdNewScanResult:
+			return nil
+		case nl80211CmdScanAborted:
+			return fmt.Errorf("scan aborted on %v", ifindex)
+		}
+	}
+}
+
+// scanResults dumps the kernel's cached BSS table for ifindex.
+func (n *nl80211Client) scanResults(ifindex uint32) ([]WifiOption, error) {
+	attrs := encodeAttrU32(nl80211AttrIfindex, ifindex)
+	msgs, err := n.conn.execute(n.family, nl80211CmdGetScan, 0, unix.NLM_F_DUMP, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("nl80211 get scan: %v", err)
+	}
+
+	var res []WifiOption
+	for _, m := range msgs {
+		for _, a := range parseAttrs(m[4:]) {
+			if a.Type != nl80211AttrBSS {
+				continue
+			}
+			opt, ok := parseBSS(a.Data)
+			if ok {
+				res = append(res, opt)
+			}
+		}
+	}
+	return res, nil
+}
+
+// currentBSS returns the BSS the kernel currently considers associated on
+// ifindex, if any.
+func (n *nl80211Client) currentBSS(ifindex uint32) (*WifiOption, error) {
+	attrs := encodeAttrU32(nl80211AttrIfindex, ifindex)
+	msgs, err := n.conn.execute(n.family, nl80211CmdGetScan, 0, unix.NLM_F_DUMP, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("nl80211 get scan: %v", err)
+	}
+	for _, m := range msgs {
+		for _, a := range parseAttrs(m[4:]) {
+			if a.Type != nl80211AttrBSS {
+				continue
+			}
+			bssAttrs := parseAttrs(a.Data)
+			status, ok := findAttr(bssAttrs, nl80211BSSStatus)
+			if !ok || len(status) < 4 || binary.LittleEndian.Uint32(status) != nl80211BSSStatusAssociated {
+				continue
+			}
+			if opt, ok := parseBSS(a.Data); ok {
+				return &opt, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func parseBSS(b []byte) (WifiOption, bool) {
+	attrs := parseAttrs(b)
+	opt := WifiOption{}
+
+	if bssid, ok := findAttr(attrs, nl80211BSSBSSID); ok && len(bssid) == 6 {
+		opt.BSSID = net.HardwareAddr(bssid)
+	}
+	if freq, ok := findAttr(attrs, nl80211BSSFrequency); ok && len(freq) >= 4 {
+		opt.Freq = int(binary.LittleEndian.Uint32(freq))
+	}
+	if sig, ok := findAttr(attrs, nl80211BSSSignalMBM); ok && len(sig) >= 4 {
+		// mBm -> dBm
+		opt.Signal = int(int32(binary.LittleEndian.Uint32(sig))) / 100
+	}
+	ies, ok := findAttr(attrs, nl80211BSSInformationElement)
+	if !ok {
+		return opt, false
+	}
+	essid, auth := parseIEs(ies)
+	opt.Essid = essid
+	opt.AuthSuite = auth
+	return opt, true
+}
+
+func findAttr(attrs []nlAttr, typ uint16) ([]byte, bool) {
+	for _, a := range attrs {
+		if a.Type == typ {
+			return a.Data, true
+		}
+	}
+	return nil, false
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
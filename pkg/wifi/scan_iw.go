@@ -0,0 +1,125 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build iw
+
+package wifi
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// This file holds the original iwlist/iwconfig/iwgetid-based scanner. It's
+// kept as an opt-in fallback (build with `-tags iw`) for platforms where the
+// nl80211 genetlink path in scan_nl80211.go isn't available; the default
+// build uses nl80211 directly and doesn't need the wireless-tools binaries
+// on $PATH at all.
+
+var (
+	// RegEx for parsing iwlist output
+	cellRE       = regexp.MustCompile("(?m)^\\s*Cell")
+	essidRE      = regexp.MustCompile("(?m)^\\s*ESSID.*")
+	encKeyOptRE  = regexp.MustCompile("(?m)^\\s*Encryption key:(on|off)$")
+	wpa2RE       = regexp.MustCompile("(?m)^\\s*IE: IEEE 802.11i/WPA2 Version 1$")
+	authSuitesRE = regexp.MustCompile("(?m)^\\s*Authentication Suites .*$")
+
+	// RegEx for parsing iwconfig output
+	iwconfigRE = regexp.MustCompile("(?m)^[a-zA-Z0-9]+\\s*IEEE 802.11.*$")
+)
+
+func (w WifiWorker) ScanInterfaces() ([]string, error) {
+	o, err := exec.Command("iwconfig").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("iwconfig: %v (%v)", string(o), err)
+	}
+	return parseIwconfig(o), nil
+}
+
+func parseIwconfig(o []byte) (res []string) {
+	interfaces := iwconfigRE.FindAll(o, -1)
+	for _, i := range interfaces {
+		res = append(res, strings.Split(string(i), " ")[0])
+	}
+	return
+}
+
+func (w WifiWorker) ScanWifi() ([]WifiOption, error) {
+	o, err := exec.Command("iwlist", w.Interface, "scanning").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("iwlist: %v (%v)", string(o), err)
+	}
+	return parseIwlistOut(o), nil
+}
+
+/*
+ * Assumptions:
+ *	1) Cell, essid, and encryption key option are 1:1 match
+ *	2) We only support IEEE 802.11i/WPA2 Version 1
+ *	3) Each Wifi only support (1) authentication suites (based on observations)
+ *
+ * This fallback doesn't have access to the BSSID/frequency/signal that
+ * nl80211 gives us for free, so those fields are left zero-valued.
+ */
+
+func parseIwlistOut(o []byte) []WifiOption {
+	cells := cellRE.FindAllIndex(o, -1)
+	essids := essidRE.FindAll(o, -1)
+	encKeyOpts := encKeyOptRE.FindAll(o, -1)
+
+	if cells == nil {
+		return nil
+	}
+
+	var res []WifiOption
+	knownEssids := make(map[string]bool)
+
+	// Assemble all the Wifi options
+	for i := 0; i < len(cells); i++ {
+		essid := strings.Trim(strings.Split(string(essids[i]), ":")[1], "\"\n")
+		if knownEssids[essid] {
+			continue
+		}
+		knownEssids[essid] = true
+		encKeyOpt := strings.Trim(strings.Split(string(encKeyOpts[i]), ":")[1], "\n")
+		if encKeyOpt == "off" {
+			res = append(res, WifiOption{Essid: essid, AuthSuite: AuthNone})
+			continue
+		}
+		// Find the proper Authentication Suites
+		start, end := cells[i][0], len(o)
+		if i != len(cells)-1 {
+			end = cells[i+1][0]
+		}
+		// Narrow down the scope when looking for WPA Tag
+		wpa2SearchArea := o[start:end]
+		l := wpa2RE.FindIndex(wpa2SearchArea)
+		if l == nil {
+			res = append(res, WifiOption{Essid: essid, AuthSuite: AuthNotSupported})
+			continue
+		}
+		// Narrow down the scope when looking for Authorization Suites
+		authSearchArea := wpa2SearchArea[l[0]:]
+		authSuites := strings.Trim(strings.Split(string(authSuitesRE.Find(authSearchArea)), ":")[1], "\n ")
+		switch authSuites {
+		case "PSK":
+			res = append(res, WifiOption{Essid: essid, AuthSuite: AuthPSK})
+		case "802.1x":
+			res = append(res, WifiOption{Essid: essid, AuthSuite: Auth8021X})
+		default:
+			res = append(res, WifiOption{Essid: essid, AuthSuite: AuthNotSupported})
+		}
+	}
+	return res
+}
+
+func (w WifiWorker) ScanCurrentWifi() (string, error) {
+	o, err := exec.Command("iwgetid", "-r").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(o), " \n"), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !iw
+
+package wifi
+
+import "fmt"
+
+// ScanInterfaces lists wireless interfaces by asking nl80211 directly,
+// rather than scraping `iwconfig`'s text output.
+func (w WifiWorker) ScanInterfaces() ([]string, error) {
+	c, err := newNl80211Client()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.interfaces()
+}
+
+// ScanWifi triggers an nl80211 scan on w.Interface and returns the resulting
+// BSS table, replacing the old iwlist-based parser (which assumed a 1:1
+// cell/essid/encryption match and only understood WPA2/PSK or WPA2/802.1x).
+func (w WifiWorker) ScanWifi() ([]WifiOption, error) {
+	c, err := newNl80211Client()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	ifindex, err := ifindexOf(w.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.triggerScan(ifindex); err != nil {
+		return nil, err
+	}
+	if err := c.waitForScanDone(ifindex); err != nil {
+		return nil, fmt.Errorf("scan %v: %v", w.Interface, err)
+	}
+	res, err := c.scanResults(ifindex)
+	if err != nil {
+		return nil, fmt.Errorf("scan %v: %v", w.Interface, err)
+	}
+	return res, nil
+}
+
+// ScanCurrentWifi returns the ESSID of the BSS w.Interface is currently
+// associated to, read from nl80211's own notion of the current BSS instead
+// of shelling out to `iwgetid -r`.
+func (w WifiWorker) ScanCurrentWifi() (string, error) {
+	c, err := newNl80211Client()
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	ifindex, err := ifindexOf(w.Interface)
+	if err != nil {
+		return "", err
+	}
+	bss, err := c.currentBSS(ifindex)
+	if err != nil {
+		return "", err
+	}
+	if bss == nil {
+		return "", fmt.Errorf("%v is not associated to any network", w.Interface)
+	}
+	return bss.Essid, nil
+}
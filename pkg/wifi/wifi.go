@@ -6,54 +6,47 @@ package wifi
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
+	"net"
 	"os/exec"
-	"regexp"
-	"strings"
-	"time"
-
-	"github.com/u-root/u-root/pkg/wpa/passphrase"
-)
-
-const (
-	nopassphrase = `network={
-		ssid="%s"
-		proto=RSN
-		key_mgmt=NONE
-	}`
-	eap = `network={
-		ssid="%s"
-		key_mgmt=WPA-EAP
-		identity="%s"
-		password="%s"
-	}`
-)
-
-var (
-	// RegEx for parsing iwlist output
-	cellRE       = regexp.MustCompile("(?m)^\\s*Cell")
-	essidRE      = regexp.MustCompile("(?m)^\\s*ESSID.*")
-	encKeyOptRE  = regexp.MustCompile("(?m)^\\s*Encryption key:(on|off)$")
-	wpa2RE       = regexp.MustCompile("(?m)^\\s*IE: IEEE 802.11i/WPA2 Version 1$")
-	authSuitesRE = regexp.MustCompile("(?m)^\\s*Authentication Suites .*$")
-
-	// RegEx for parsing iwconfig output
-	iwconfigRE = regexp.MustCompile("(?m)^[a-zA-Z0-9]+\\s*IEEE 802.11.*$")
 )
 
-type SecProto int
+// AuthSuite is a bitmask of the AKM (Authentication and Key Management)
+// suites a BSS advertises in its RSN/WPA information elements. A single
+// BSS can, and often does, advertise more than one suite (e.g. a
+// WPA2/WPA3-SAE transition-mode AP), so unlike the old single-value
+// SecProto this is meant to be tested with &, not ==.
+type AuthSuite uint32
 
 const (
-	NoEnc SecProto = iota
-	WpaPsk
-	WpaEap
-	NotSupportedProto
+	// AuthNone means the BSS is open (no RSN/WPA IE at all).
+	AuthNone AuthSuite = 0
+	// AuthPSK is WPA2-Personal (key_mgmt=WPA-PSK).
+	AuthPSK AuthSuite = 1 << iota
+	// Auth8021X is WPA2-Enterprise (key_mgmt=WPA-EAP).
+	Auth8021X
+	// AuthSAE is WPA3-Personal (key_mgmt=SAE).
+	AuthSAE
+	// AuthFTPSK is 802.11r fast-transition PSK.
+	AuthFTPSK
+	// AuthOWE is Opportunistic Wireless Encryption (enhanced open).
+	AuthOWE
+	// AuthNotSupported is set when the RSN IE advertises an AKM suite we
+	// don't recognize, lists no AKM suites, or is too short to parse.
+	AuthNotSupported
 )
 
+// WifiOption describes one BSS seen during a scan.
 type WifiOption struct {
-	Essid     string
-	AuthSuite SecProto
+	Essid string
+	BSSID net.HardwareAddr
+	// Freq is the center frequency of the channel in MHz, e.g. 2412.
+	Freq int
+	// Signal is the last observed signal strength in dBm (negative,
+	// closer to 0 is stronger).
+	Signal int
+	// AuthSuite is the bitmask of AKM suites this BSS advertises. It is
+	// AuthNone for open networks.
+	AuthSuite AuthSuite
 }
 
 type Wifi interface {
@@ -61,6 +54,7 @@ type Wifi interface {
 	ScanWifi() ([]WifiOption, error)
 	ScanCurrentWifi() (string, error)
 	Connect(a ...string) error
+	ConnectCredentials(cr Credentials) error
 }
 
 type WifiWorker struct {
@@ -74,156 +68,22 @@ func NewWorker(i string) (WifiWorker, error) {
 	return WifiWorker{i}, nil
 }
 
-func (w WifiWorker) ScanInterfaces() ([]string, error) {
-	o, err := exec.Command("iwconfig").CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("iwconfig: %v (%v)", string(o), err)
-	}
-	return parseIwconfig(o), nil
-}
-
-func parseIwconfig(o []byte) (res []string) {
-	interfaces := iwconfigRE.FindAll(o, -1)
-	for _, i := range interfaces {
-		res = append(res, strings.Split(string(i), " ")[0])
-	}
-	return
-}
-
-func (w WifiWorker) ScanWifi() ([]WifiOption, error) {
-	o, err := exec.Command("iwlist", w.Interface, "scanning").CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("iwlist: %v (%v)", string(o), err)
-	}
-	return parseIwlistOut(o), nil
-}
-
-/*
- * Assumptions:
- *	1) Cell, essid, and encryption key option are 1:1 match
- *	2) We only support IEEE 802.11i/WPA2 Version 1
- *	3) Each Wifi only support (1) authentication suites (based on observations)
- */
-
-func parseIwlistOut(o []byte) []WifiOption {
-	cells := cellRE.FindAllIndex(o, -1)
-	essids := essidRE.FindAll(o, -1)
-	encKeyOpts := encKeyOptRE.FindAll(o, -1)
-
-	if cells == nil {
-		return nil
-	}
-
-	var res []WifiOption
-	knownEssids := make(map[string]bool)
-
-	// Assemble all the Wifi options
-	for i := 0; i < len(cells); i++ {
-		essid := strings.Trim(strings.Split(string(essids[i]), ":")[1], "\"\n")
-		if knownEssids[essid] {
-			continue
-		}
-		knownEssids[essid] = true
-		encKeyOpt := strings.Trim(strings.Split(string(encKeyOpts[i]), ":")[1], "\n")
-		if encKeyOpt == "off" {
-			res = append(res, WifiOption{essid, NoEnc})
-			continue
-		}
-		// Find the proper Authentication Suites
-		start, end := cells[i][0], len(o)
-		if i != len(cells)-1 {
-			end = cells[i+1][0]
-		}
-		// Narrow down the scope when looking for WPA Tag
-		wpa2SearchArea := o[start:end]
-		l := wpa2RE.FindIndex(wpa2SearchArea)
-		if l == nil {
-			res = append(res, WifiOption{essid, NotSupportedProto})
-			continue
-		}
-		// Narrow down the scope when looking for Authorization Suites
-		authSearchArea := wpa2SearchArea[l[0]:]
-		authSuites := strings.Trim(strings.Split(string(authSuitesRE.Find(authSearchArea)), ":")[1], "\n ")
-		switch authSuites {
-		case "PSK":
-			res = append(res, WifiOption{essid, WpaPsk})
-		case "802.1x":
-			res = append(res, WifiOption{essid, WpaEap})
-		default:
-			res = append(res, WifiOption{essid, NotSupportedProto})
-		}
-	}
-	return res
-}
-
-func (w WifiWorker) ScanCurrentWifi() (string, error) {
-	o, err := exec.Command("iwgetid", "-r").CombinedOutput()
-	if err != nil {
-		return "", err
-	}
-	return strings.Trim(string(o), " \n"), nil
-}
-
+// Connect is the deprecated positional-argument form of ConnectCredentials;
+// see Controller.Connect.
+//
+// Deprecated: use ConnectCredentials.
 func (w WifiWorker) Connect(a ...string) error {
-	// format of a: [essid, pass, id]
-	conf, err := generateConfig(a...)
-	if err != nil {
-		return err
-	}
-
-	if err := ioutil.WriteFile("/tmp/wifi.conf", conf, 0444); err != nil {
-		return fmt.Errorf("/tmp/wifi.conf: %v", err)
-	}
-
-	c := make(chan error, 1)
-
-	// There's no telling how long the supplicant will take, but on the other hand,
-	// it's been almost instantaneous. But, further, it needs to keep running.
-	go func() {
-		cmd := exec.Command("wpa_supplicant", "-i"+w.Interface, "-c/tmp/wifi.conf")
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr //For an easier time debugging
-		cmd.Run()
-	}()
-
-	// dhclient might never return on incorect passwords or identity
-	go func() {
-		cmd := exec.Command("dhclient", "-ipv4=true", "-ipv6=false", "-verbose", w.Interface)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr //For an easier time debugging
-		if err := cmd.Run(); err != nil {
-			c <- err
-		} else {
-			c <- nil
-		}
-	}()
-
-	// Each connect attempt is given a 30-second
-	// window before timing out
-	t := time.AfterFunc(30*time.Second, func() {
-		c <- fmt.Errorf("Connection Timeout")
-	})
-
-	err = <-c
-	t.Stop()
-	if err != nil {
-		return fmt.Errorf("error: %v", err)
-	}
-	return nil
+	return NewController(w.Interface).Connect(a...)
 }
 
-func generateConfig(a ...string) (conf []byte, err error) {
-	// format of a: [essid, pass, id]
-	switch {
-	case len(a) == 3:
-		conf = []byte(fmt.Sprintf(eap, a[0], a[2], a[1]))
-	case len(a) == 2:
-		conf, err = passphrase.Run(a[0], a[1])
-		if err != nil {
-			return nil, fmt.Errorf("essid: %v, pass: %v : %v", a[0], a[1], err)
-		}
-	case len(a) == 1:
-		conf = []byte(fmt.Sprintf(nopassphrase, a[0]))
-	default:
-		return nil, fmt.Errorf("generateConfig needs 1, 2, or 3 args")
-	}
-	return
+// ConnectCredentials drives w.Interface through the Controller state
+// machine to associate, authenticate, and obtain a lease, using cr to
+// configure the network -- including WPA3-SAE and WPA-Enterprise methods
+// the old positional Connect couldn't express. It used to spawn
+// wpa_supplicant and dhclient as fire-and-forget goroutines racing a blind
+// 30-second timer; now it supervises wpa_supplicant over its control
+// socket, so a bad passphrase fails fast via CTRL-EVENT-SSID-TEMP-DISABLED
+// instead of waiting the full 30 seconds for dhclient to give up.
+func (w WifiWorker) ConnectCredentials(cr Credentials) error {
+	return NewController(w.Interface).ConnectCredentials(cr)
 }
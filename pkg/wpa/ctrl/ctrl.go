@@ -0,0 +1,253 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ctrl is a client for wpa_supplicant's control interface protocol,
+// spoken over a pair of unix datagram sockets (one per client, conventionally
+// under /var/run/wpa_supplicant/<iface>). It lets callers drive an already
+// running wpa_supplicant (ADD_NETWORK, SET_NETWORK, SELECT_NETWORK, ...) and
+// subscribe to its unsolicited CTRL-EVENT-*/WPS-* messages, instead of
+// writing out a static config file and respawning wpa_supplicant for every
+// connection attempt.
+package ctrl
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCtrlDir is wpa_supplicant's default -C control interface directory.
+const DefaultCtrlDir = "/var/run/wpa_supplicant"
+
+// RequestTimeout bounds how long Request waits for a reply to a command
+// before giving up; wpa_supplicant replies to its control socket
+// essentially immediately, so a stuck request means the daemon is wedged.
+var RequestTimeout = 5 * time.Second
+
+// Conn is a connection to one wpa_supplicant control interface.
+type Conn struct {
+	conn  *net.UnixConn
+	local string
+}
+
+// Dial connects to the control interface for iface under dir (normally
+// ctrl.DefaultCtrlDir). Like wpa_cli, we bind our own local socket path
+// rather than connecting with an ephemeral/unbound one, since
+// wpa_supplicant replies by sendto'ing back to our bound address.
+func Dial(dir, iface string) (*Conn, error) {
+	remote := filepath.Join(dir, iface)
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("wpa_ctrl_%s_%d", iface, os.Getpid()))
+	os.Remove(local)
+
+	laddr := &net.UnixAddr{Name: local, Net: "unixgram"}
+	raddr := &net.UnixAddr{Name: remote, Net: "unixgram"}
+
+	conn, err := net.DialUnix("unixgram", laddr, raddr)
+	if err != nil {
+		os.Remove(local)
+		return nil, fmt.Errorf("dial %v: %v", remote, err)
+	}
+	return &Conn{conn: conn, local: local}, nil
+}
+
+// Close releases the connection and its local socket file.
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	os.Remove(c.local)
+	return err
+}
+
+// Request sends a raw control command and returns wpa_supplicant's reply,
+// with its trailing newline stripped.
+func (c *Conn) Request(cmd string) (string, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(RequestTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		return "", fmt.Errorf("%v: %v", cmd, err)
+	}
+	buf := make([]byte, 4096)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", cmd, err)
+	}
+	return strings.TrimRight(string(buf[:n]), "\n"), nil
+}
+
+func (c *Conn) requestOK(cmd string) error {
+	reply, err := c.Request(cmd)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) != "OK" {
+		return fmt.Errorf("%v: %v", cmd, reply)
+	}
+	return nil
+}
+
+// Scan asks wpa_supplicant to start a scan; results show up asynchronously
+// as a CTRL-EVENT-SCAN-RESULTS event and can then be read with
+// ScanResults.
+func (c *Conn) Scan() error {
+	return c.requestOK("SCAN")
+}
+
+// BSS is one row of a SCAN_RESULTS reply.
+type BSS struct {
+	BSSID     net.HardwareAddr
+	Frequency int
+	// SignalLevel is in dBm.
+	SignalLevel int
+	Flags       string
+	SSID        string
+}
+
+// ScanResults returns the BSS table from the most recently completed scan.
+func (c *Conn) ScanResults() ([]BSS, error) {
+	reply, err := c.Request("SCAN_RESULTS")
+	if err != nil {
+		return nil, err
+	}
+	var res []BSS
+	lines := strings.Split(reply, "\n")
+	// First line is the column header.
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) < 5 {
+			continue
+		}
+		bssid, err := net.ParseMAC(f[0])
+		if err != nil {
+			continue
+		}
+		freq, _ := strconv.Atoi(f[1])
+		sig, _ := strconv.Atoi(f[2])
+		res = append(res, BSS{
+			BSSID:       bssid,
+			Frequency:   freq,
+			SignalLevel: sig,
+			Flags:       f[3],
+			SSID:        f[4],
+		})
+	}
+	return res, nil
+}
+
+// AddNetwork creates a new (disabled, unconfigured) network block and
+// returns its network id, for use with SetNetwork/SelectNetwork/
+// EnableNetwork/RemoveNetwork.
+func (c *Conn) AddNetwork() (int, error) {
+	reply, err := c.Request("ADD_NETWORK")
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(reply))
+	if err != nil {
+		return 0, fmt.Errorf("ADD_NETWORK: unexpected reply %q", reply)
+	}
+	return id, nil
+}
+
+// SetNetwork sets one wpa_supplicant.conf network variable (e.g. "ssid",
+// "psk", "key_mgmt", "identity", "password", "phase2", "ca_cert", ...) on
+// network id. Callers are responsible for quoting string values the way
+// wpa_supplicant expects (quoted literal vs. hex/NONE).
+func (c *Conn) SetNetwork(id int, variable, value string) error {
+	return c.requestOK(fmt.Sprintf("SET_NETWORK %d %s %s", id, variable, value))
+}
+
+// EnableNetwork enables network id so it's eligible to be associated to.
+func (c *Conn) EnableNetwork(id int) error {
+	return c.requestOK(fmt.Sprintf("ENABLE_NETWORK %d", id))
+}
+
+// SelectNetwork enables network id and disables every other configured
+// network, i.e. "connect to this one now".
+func (c *Conn) SelectNetwork(id int) error {
+	return c.requestOK(fmt.Sprintf("SELECT_NETWORK %d", id))
+}
+
+// RemoveNetwork deletes network id.
+func (c *Conn) RemoveNetwork(id int) error {
+	return c.requestOK(fmt.Sprintf("REMOVE_NETWORK %d", id))
+}
+
+// Network is one row of a LIST_NETWORKS reply.
+type Network struct {
+	ID    int
+	SSID  string
+	BSSID string
+	Flags string
+}
+
+// ListNetworks returns every network block currently configured in
+// wpa_supplicant, in the order LIST_NETWORKS reports them.
+func (c *Conn) ListNetworks() ([]Network, error) {
+	reply, err := c.Request("LIST_NETWORKS")
+	if err != nil {
+		return nil, err
+	}
+	var res []Network
+	lines := strings.Split(reply, "\n")
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) < 3 {
+			continue
+		}
+		id, _ := strconv.Atoi(f[0])
+		n := Network{ID: id, SSID: f[1], BSSID: f[2]}
+		if len(f) > 3 {
+			n.Flags = f[3]
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// Status returns wpa_supplicant's STATUS reply as a key/value map (wpa_state,
+// ssid, bssid, ip_address, key_mgmt, ...).
+func (c *Conn) Status() (map[string]string, error) {
+	reply, err := c.Request("STATUS")
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]string)
+	for _, line := range strings.Split(reply, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		status[kv[0]] = kv[1]
+	}
+	return status, nil
+}
+
+// QuoteString wraps a wpa_supplicant network string value in quotes, the
+// form it expects for ssid/psk/identity/password/ca_cert/etc in SET_NETWORK,
+// as opposed to the unquoted hex-digit form. Embedded '\' and '"' are
+// backslash-escaped per wpa_supplicant's quoted-string config syntax, so an
+// ESSID or passphrase containing one doesn't desync the value wpa_supplicant
+// parses.
+func QuoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '\\' || c == '"' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
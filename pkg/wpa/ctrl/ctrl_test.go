@@ -0,0 +1,25 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrl
+
+import "testing"
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"home", `"home"`},
+		{"", `""`},
+		{`has "quotes"`, `"has \"quotes\""`},
+		{`back\slash`, `"back\\slash"`},
+		{`mix\ "of" both`, `"mix\\ \"of\" both"`},
+	}
+	for _, tt := range tests {
+		if got := QuoteString(tt.in); got != tt.want {
+			t.Errorf("QuoteString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrl
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// Event is one unsolicited message from wpa_supplicant: typically a bare
+// CTRL-EVENT-* or WPS-* name, optionally followed by space-separated
+// "key=value" parameters (e.g. "CTRL-EVENT-SSID-TEMP-DISABLED
+// id=0 ssid="home" auth_failures=1 duration=10 reason=WRONG_KEY").
+// Informational lines such as "Associated with ..." are delivered too;
+// for those Name is just the line's first word and Raw holds the full line.
+type Event struct {
+	// Priority is wpa_supplicant's own 0-4 debug level, stripped off the
+	// front of the line (e.g. the "3" in "<3>CTRL-EVENT-CONNECTED ...").
+	Priority int
+	Name     string
+	Params   map[string]string
+	Raw      string
+}
+
+// Attach subscribes this connection to wpa_supplicant's unsolicited event
+// stream; Monitor only delivers events after Attach succeeds.
+func (c *Conn) Attach() error {
+	return c.requestOK("ATTACH")
+}
+
+// Detach unsubscribes, leaving the connection usable for ordinary Request
+// calls only.
+func (c *Conn) Detach() error {
+	return c.requestOK("DETACH")
+}
+
+// Monitor reads unsolicited events until the connection closes or read
+// fails, sending each to events. It's meant to run in its own goroutine
+// fed from an Attach'd Conn.
+func (c *Conn) Monitor(events chan<- Event) error {
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if line == "" && err != nil {
+			return err
+		}
+		events <- parseEvent(strings.TrimRight(line, "\n"))
+	}
+}
+
+func parseEvent(line string) Event {
+	evt := Event{Raw: line, Params: map[string]string{}}
+
+	if strings.HasPrefix(line, "<") {
+		if i := strings.IndexByte(line, '>'); i > 0 {
+			if p, err := strconv.Atoi(line[1:i]); err == nil {
+				evt.Priority = p
+			}
+			line = line[i+1:]
+		}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return evt
+	}
+	evt.Name = fields[0]
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) == 2 {
+			evt.Params[kv[0]] = kv[1]
+		}
+	}
+	return evt
+}
+
+// Known event names we specifically act on. wpa_supplicant emits many more
+// than this; callers match on the string when they need one we haven't
+// bothered to name.
+const (
+	EventScanResults     = "CTRL-EVENT-SCAN-RESULTS"
+	EventConnected       = "CTRL-EVENT-CONNECTED"
+	EventDisconnected    = "CTRL-EVENT-DISCONNECTED"
+	EventSSIDTempDisabled = "CTRL-EVENT-SSID-TEMP-DISABLED"
+	EventEAPSuccess      = "CTRL-EVENT-EAP-SUCCESS"
+	EventEAPFailure      = "CTRL-EVENT-EAP-FAILURE"
+	EventWPSSuccess      = "WPS-SUCCESS"
+	EventWPSFail         = "WPS-FAIL"
+	EventWPSTimeout      = "WPS-TIMEOUT"
+)